@@ -1,12 +1,24 @@
 package drivers
 
 import (
+	"encoding/json"
+	"fmt"
 	"regexp"
 	"sort"
 	"strconv"
 )
 
-var rawConfigPattern = regexp.MustCompile(`^raw\.qemu\.config\.([^.\[]+)(?:\[(\d)\])?(?:\.(.+))?$`)
+// rawConfigPattern matches raw.qemu.config.* keys. The section index may be a
+// plain (possibly multi-digit) number, e.g. "chardev[11]", or a named lookup
+// of the form "chardev[@id=virtio-net0]" that addresses whichever generated
+// section of that name has a matching id-entry value (see
+// qemuResolveNamedRawConfigKeys).
+var rawConfigPattern = regexp.MustCompile(`^raw\.qemu\.config\.([^.\[]+)(?:\[(\d+)\]|\[@([^=\]]+)=([^\]]+)\])?(?:\.(.+))?$`)
+
+// rawConfigPatchKey is the single raw.qemu.config.* key that carries a JSON
+// Patch (RFC 6902) document, as an alternative to the flat
+// raw.qemu.config.section[i].key=value form handled above.
+const rawConfigPatchKey = "raw.qemu.config.patch"
 
 type rawConfigKey struct {
 	sectionName string
@@ -14,6 +26,17 @@ type rawConfigKey struct {
 	entryKey    string
 }
 
+// namedRawConfigKey is an unresolved raw.qemu.config.section[@idKey=idValue]
+// override, produced by qemuExtractRawConfigKeys and turned into a concrete
+// rawConfigKey by qemuResolveNamedRawConfigKeys once the generated
+// []cfgSection is available.
+type namedRawConfigKey struct {
+	sectionName string
+	idKey       string
+	idValue     string
+	entryKey    string
+}
+
 func sortedConfigKeys(cfgMap map[rawConfigKey]string) []rawConfigKey {
 	rv := []rawConfigKey{}
 
@@ -32,11 +55,20 @@ func sortedConfigKeys(cfgMap map[rawConfigKey]string) []rawConfigKey {
 
 // Extracts all raw.qemu.config.* keys into a separate map. It also normalizes
 // all sections to have an explicit index, so that keys like
-// "raw.config.qemu.section.entry" become "raw.config.qemu.section[0].entry"
-func qemuExtractRawConfigKeys(expandedConfig map[string]string) map[rawConfigKey]string {
+// "raw.qemu.config.section.entry" become "raw.qemu.config.section[0].entry".
+// Named-index keys ("section[@idKey=idValue].entry") can't be resolved to a
+// concrete index without the generated []cfgSection, so they're returned
+// separately for qemuResolveNamedRawConfigKeys to handle.
+func qemuExtractRawConfigKeys(expandedConfig map[string]string) (map[rawConfigKey]string, map[namedRawConfigKey]string) {
 	rv := map[rawConfigKey]string{}
+	named := map[namedRawConfigKey]string{}
 
 	for rawKey, value := range expandedConfig {
+		if rawKey == rawConfigPatchKey {
+			// Handled separately by qemuApplyRawConfigPatch.
+			continue
+		}
+
 		matches := rawConfigPattern.FindStringSubmatch(rawKey)
 
 		if len(matches) == 0 {
@@ -44,48 +76,139 @@ func qemuExtractRawConfigKeys(expandedConfig map[string]string) map[rawConfigKey
 			continue
 		}
 
+		if matches[3] != "" {
+			named[namedRawConfigKey{
+				sectionName: matches[1],
+				idKey:       matches[3],
+				idValue:     matches[4],
+				entryKey:    matches[5],
+			}] = value
+
+			continue
+		}
+
 		k := rawConfigKey{
 			sectionName: matches[1],
 			// default index is 0
 			index:    0,
-			entryKey: matches[3],
+			entryKey: matches[5],
 		}
 
 		if matches[2] != "" {
-			i, err := strconv.Atoi(matches[2])
-			if err != nil || i > 9 || i < 0 {
-				panic("unexpected failure in index parsing")
+			i, err := strconv.ParseUint(matches[2], 10, 64)
+			if err != nil {
+				// too large to fit a uint; treat like an unmatched key
+				continue
 			}
+
 			k.index = uint(i)
 		}
 
 		rv[k] = value
 	}
 
-	return rv
+	return rv, named
 }
 
-func qemuRawCfgOverride(cfg []cfgSection, expandedConfig map[string]string) []cfgSection {
-	tmp := qemuExtractRawConfigKeys(expandedConfig)
+// qemuResolveNamedRawConfigKeys turns named (section[@idKey=idValue]) raw
+// config overrides into concrete rawConfigKey entries, by building a reverse
+// index of (sectionName, idKey, idValue) -> concrete index from the
+// generated []cfgSection and looking each named key up in it.
+func qemuResolveNamedRawConfigKeys(cfg []cfgSection, named map[namedRawConfigKey]string) (map[rawConfigKey]string, error) {
+	indexes := qemuSectionIndexes(cfg)
+
+	type idLookupKey struct {
+		sectionName string
+		idKey       string
+		idValue     string
+	}
 
-	if len(tmp) == 0 {
-		// If no keys are found, we return the cfg unmodified.
-		return cfg
+	reverse := map[idLookupKey]uint{}
+	for i, section := range cfg {
+		for _, entry := range section.entries {
+			reverse[idLookupKey{section.name, entry.key, entry.value}] = indexes[i]
+		}
 	}
 
-	newCfg := []cfgSection{}
-	sectionNameCountMap := map[string]uint{}
+	rv := map[rawConfigKey]string{}
+	for k, v := range named {
+		index, ok := reverse[idLookupKey{k.sectionName, k.idKey, k.idValue}]
+		if !ok {
+			return nil, fmt.Errorf("No %q section with %s=%q found", k.sectionName, k.idKey, k.idValue)
+		}
+
+		rv[rawConfigKey{sectionName: k.sectionName, index: index, entryKey: k.entryKey}] = v
+	}
+
+	return rv, nil
+}
 
-	for _, section := range cfg {
-		count, ok := sectionNameCountMap[section.name]
+// qemuSectionIndexes returns, for each section in cfg, its 0-based index
+// among sections sharing its name, matching the indexing qemuApplyFlatRawConfig
+// assigns when applying flat raw.qemu.config.section[i] overrides.
+func qemuSectionIndexes(cfg []cfgSection) []uint {
+	indexes := make([]uint, len(cfg))
+	counts := map[string]uint{}
 
-		if ok {
-			sectionNameCountMap[section.name] = count + 1
-		} else {
-			sectionNameCountMap[section.name] = 1
+	for i, section := range cfg {
+		indexes[i] = counts[section.name]
+		counts[section.name]++
+	}
+
+	return indexes
+}
+
+// qemuRawCfgOverride applies user-provided raw.qemu.config.* overrides to the
+// generated QEMU config. Flat raw.qemu.config.section[i].key=value overrides
+// (handled by qemuApplyFlatRawConfig) are applied first, followed by the
+// raw.qemu.config.patch JSON Patch document (if any), so the two forms
+// compose: the patch sees the already flat-overridden tree.
+func qemuRawCfgOverride(cfg []cfgSection, expandedConfig map[string]string) ([]cfgSection, error) {
+	tmp, named := qemuExtractRawConfigKeys(expandedConfig)
+
+	if len(named) > 0 {
+		resolved, err := qemuResolveNamedRawConfigKeys(cfg, named)
+		if err != nil {
+			return nil, fmt.Errorf("Resolve named raw.qemu.config index: %w", err)
+		}
+
+		for k, v := range resolved {
+			tmp[k] = v
 		}
+	}
+
+	newCfg := cfg
+	if len(tmp) > 0 {
+		newCfg = qemuApplyFlatRawConfig(cfg, tmp)
+	}
+
+	patch, ok := expandedConfig[rawConfigPatchKey]
+	if !ok || patch == "" {
+		return newCfg, nil
+	}
+
+	var ops []rawConfigPatchOp
+	err := json.Unmarshal([]byte(patch), &ops)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %q: %w", rawConfigPatchKey, err)
+	}
+
+	newCfg, err = qemuApplyRawConfigPatch(newCfg, ops)
+	if err != nil {
+		return nil, fmt.Errorf("Apply %q: %w", rawConfigPatchKey, err)
+	}
+
+	return newCfg, nil
+}
+
+// qemuApplyFlatRawConfig applies the flat raw.qemu.config.section[i].key=value
+// overrides extracted by qemuExtractRawConfigKeys to cfg.
+func qemuApplyFlatRawConfig(cfg []cfgSection, tmp map[rawConfigKey]string) []cfgSection {
+	newCfg := []cfgSection{}
+	indexes := qemuSectionIndexes(cfg)
 
-		index := sectionNameCountMap[section.name] - 1
+	for i, section := range cfg {
+		index := indexes[i]
 		sk := rawConfigKey{section.name, index, ""}
 
 		if val, ok := tmp[sk]; ok {
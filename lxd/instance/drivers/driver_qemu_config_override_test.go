@@ -0,0 +1,164 @@
+package drivers
+
+import (
+	"fmt"
+	"testing"
+)
+
+// manyChardevs builds a config with n chardev sections, each with an "id"
+// entry (chardev0, chardev1, ...) and a "backend" entry set to "pty".
+func manyChardevs(n int) []cfgSection {
+	cfg := make([]cfgSection, 0, n)
+
+	for i := 0; i < n; i++ {
+		cfg = append(cfg, cfgSection{
+			name: "chardev",
+			entries: []cfgEntry{
+				{key: "id", value: fmt.Sprintf("chardev%d", i)},
+				{key: "backend", value: "pty"},
+			},
+		})
+	}
+
+	return cfg
+}
+
+func TestQemuRawCfgOverrideMultiDigitIndex(t *testing.T) {
+	cfg := manyChardevs(12)
+
+	expanded := map[string]string{
+		"raw.qemu.config.chardev[11].backend": "socket",
+	}
+
+	out, err := qemuRawCfgOverride(cfg, expanded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(out) != 12 {
+		t.Fatalf("expected 12 sections, got %d", len(out))
+	}
+
+	got := out[11].entries[1].value
+	if got != "socket" {
+		t.Fatalf("expected chardev[11].backend=socket, got %q", got)
+	}
+
+	// Every other chardev should be untouched.
+	for i, section := range out {
+		if i == 11 {
+			continue
+		}
+
+		if section.entries[1].value != "pty" {
+			t.Fatalf("expected chardev[%d].backend=pty, got %q", i, section.entries[1].value)
+		}
+	}
+}
+
+func TestQemuRawCfgOverrideNamedIndex(t *testing.T) {
+	cfg := manyChardevs(15)
+
+	expanded := map[string]string{
+		`raw.qemu.config.chardev[@id=chardev13].backend`: "socket",
+	}
+
+	out, err := qemuRawCfgOverride(cfg, expanded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out[13].entries[1].value != "socket" {
+		t.Fatalf("expected chardev13 backend=socket, got %q", out[13].entries[1].value)
+	}
+
+	if out[12].entries[1].value != "pty" {
+		t.Fatalf("expected chardev12 untouched, got %q", out[12].entries[1].value)
+	}
+}
+
+func TestQemuRawCfgOverrideNamedIndexNotFound(t *testing.T) {
+	cfg := manyChardevs(3)
+
+	expanded := map[string]string{
+		`raw.qemu.config.chardev[@id=does-not-exist].backend`: "socket",
+	}
+
+	_, err := qemuRawCfgOverride(cfg, expanded)
+	if err == nil {
+		t.Fatalf("expected error for unresolvable named index")
+	}
+}
+
+func TestQemuExtractRawConfigKeys(t *testing.T) {
+	cases := []struct {
+		name        string
+		rawKey      string
+		wantNamed   bool
+		wantSection string
+		wantIndex   uint
+		wantIDKey   string
+		wantIDValue string
+		wantEntry   string
+	}{
+		{
+			name:        "implicit index",
+			rawKey:      "raw.qemu.config.pci.romfile",
+			wantSection: "pci",
+			wantEntry:   "romfile",
+		},
+		{
+			name:        "single digit index",
+			rawKey:      "raw.qemu.config.chardev[3].backend",
+			wantSection: "chardev",
+			wantIndex:   3,
+			wantEntry:   "backend",
+		},
+		{
+			name:        "multi digit index",
+			rawKey:      "raw.qemu.config.chardev[142].backend",
+			wantSection: "chardev",
+			wantIndex:   142,
+			wantEntry:   "backend",
+		},
+		{
+			name:        "named index",
+			rawKey:      "raw.qemu.config.device[@id=virtio-net0].mac",
+			wantNamed:   true,
+			wantSection: "device",
+			wantIDKey:   "id",
+			wantIDValue: "virtio-net0",
+			wantEntry:   "mac",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			flat, named := qemuExtractRawConfigKeys(map[string]string{tc.rawKey: "value"})
+
+			if tc.wantNamed {
+				if len(named) != 1 {
+					t.Fatalf("expected 1 named key, got %d", len(named))
+				}
+
+				for k := range named {
+					if k.sectionName != tc.wantSection || k.idKey != tc.wantIDKey || k.idValue != tc.wantIDValue || k.entryKey != tc.wantEntry {
+						t.Fatalf("unexpected named key: %+v", k)
+					}
+				}
+
+				return
+			}
+
+			if len(flat) != 1 {
+				t.Fatalf("expected 1 flat key, got %d", len(flat))
+			}
+
+			for k := range flat {
+				if k.sectionName != tc.wantSection || k.index != tc.wantIndex || k.entryKey != tc.wantEntry {
+					t.Fatalf("unexpected flat key: %+v", k)
+				}
+			}
+		})
+	}
+}
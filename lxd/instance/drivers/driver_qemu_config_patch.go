@@ -0,0 +1,358 @@
+package drivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// rawConfigPatchOp is a single RFC 6902 JSON Patch operation scoped to the
+// generated QEMU config sections (as opposed to an arbitrary JSON document).
+type rawConfigPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// rawConfigPatchPath addresses either a whole section (entryKey == "") or a
+// single entry within it (e.g. "/chardev[1]/backend" or "/pci/romfile").
+type rawConfigPatchPath struct {
+	sectionName string
+	index       uint
+	entryKey    string
+}
+
+// rawConfigPatchEntry and rawConfigPatchSection are the JSON-addressable
+// views of cfgEntry and cfgSection, whose own fields are unexported and so
+// can't be marshalled/unmarshalled directly.
+type rawConfigPatchEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type rawConfigPatchSection struct {
+	Comment string                `json:"comment,omitempty"`
+	Entries []rawConfigPatchEntry `json:"entries,omitempty"`
+}
+
+func toRawConfigPatchSection(s cfgSection) rawConfigPatchSection {
+	p := rawConfigPatchSection{Comment: s.comment}
+
+	for _, e := range s.entries {
+		p.Entries = append(p.Entries, rawConfigPatchEntry{Key: e.key, Value: e.value})
+	}
+
+	return p
+}
+
+func fromRawConfigPatchSection(name string, p rawConfigPatchSection) cfgSection {
+	s := cfgSection{name: name, comment: p.Comment}
+
+	for _, e := range p.Entries {
+		s.entries = append(s.entries, cfgEntry{key: e.Key, value: e.Value})
+	}
+
+	return s
+}
+
+var rawConfigPatchPathPattern = regexp.MustCompile(`^/([^/\[]+)(?:\[(\d+)\])?(?:/(.+))?$`)
+
+func parseRawConfigPatchPath(path string) (rawConfigPatchPath, error) {
+	matches := rawConfigPatchPathPattern.FindStringSubmatch(path)
+	if len(matches) == 0 {
+		return rawConfigPatchPath{}, fmt.Errorf("Invalid patch path %q", path)
+	}
+
+	p := rawConfigPatchPath{
+		sectionName: matches[1],
+		entryKey:    matches[3],
+	}
+
+	if matches[2] != "" {
+		i, err := strconv.ParseUint(matches[2], 10, 64)
+		if err != nil {
+			return rawConfigPatchPath{}, fmt.Errorf("Invalid patch path %q: %w", path, err)
+		}
+
+		p.index = uint(i)
+	}
+
+	return p, nil
+}
+
+// qemuApplyRawConfigPatch applies a sequence of RFC 6902 JSON Patch
+// operations to cfg. Sections are addressed by name and occurrence index
+// (e.g. "/chardev[1]"), and entries within a section by key (e.g.
+// "/chardev[1]/backend"). "test" failures and references to missing
+// sections or entries are returned as errors rather than applied.
+func qemuApplyRawConfigPatch(cfg []cfgSection, ops []rawConfigPatchOp) ([]cfgSection, error) {
+	// Deep-copy each section's entries: a shallow append([]cfgSection{},
+	// cfg...) would still share the entries backing array with the caller,
+	// so in-place mutators below (replace, add-over-existing-key,
+	// entry-level remove) would silently corrupt cfg.
+	newCfg := make([]cfgSection, len(cfg))
+	for i, section := range cfg {
+		newCfg[i] = cfgSection{
+			name:    section.name,
+			comment: section.comment,
+			entries: append([]cfgEntry{}, section.entries...),
+		}
+	}
+
+	for _, op := range ops {
+		path, err := parseRawConfigPatchPath(op.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case "test":
+			err = rawConfigPatchTest(newCfg, path, op.Value)
+		case "add":
+			newCfg, err = rawConfigPatchAdd(newCfg, path, op.Value)
+		case "replace":
+			newCfg, err = rawConfigPatchReplace(newCfg, path, op.Value)
+		case "remove":
+			newCfg, err = rawConfigPatchRemove(newCfg, path)
+		case "move":
+			newCfg, err = rawConfigPatchMove(newCfg, op.From, path, true)
+		case "copy":
+			newCfg, err = rawConfigPatchMove(newCfg, op.From, path, false)
+		default:
+			err = fmt.Errorf("Unsupported JSON Patch op %q", op.Op)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Op %q on %q: %w", op.Op, op.Path, err)
+		}
+	}
+
+	return newCfg, nil
+}
+
+// findRawConfigSection returns the index of the path.index'th section named
+// path.sectionName within cfg.
+func findRawConfigSection(cfg []cfgSection, path rawConfigPatchPath) (int, bool) {
+	var count uint
+
+	for i, section := range cfg {
+		if section.name != path.sectionName {
+			continue
+		}
+
+		if count == path.index {
+			return i, true
+		}
+
+		count++
+	}
+
+	return 0, false
+}
+
+// insertRawConfigSection inserts section at the position of the
+// path.index'th occurrence of path.sectionName in cfg, shifting that
+// occurrence (and everything after it) back by one — true array insert
+// semantics, as opposed to overwriting whatever currently sits there. If no
+// such occurrence exists (e.g. path.index is one past the last one), section
+// is appended at the end of cfg.
+func insertRawConfigSection(cfg []cfgSection, path rawConfigPatchPath, section cfgSection) []cfgSection {
+	pos, ok := findRawConfigSection(cfg, path)
+	if !ok {
+		pos = len(cfg)
+	}
+
+	cfg = append(cfg, cfgSection{})
+	copy(cfg[pos+1:], cfg[pos:])
+	cfg[pos] = section
+
+	return cfg
+}
+
+func findRawConfigEntry(section cfgSection, entryKey string) (int, bool) {
+	for i, entry := range section.entries {
+		if entry.key == entryKey {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// rawConfigPatchValue resolves the current value addressed by path: either
+// the whole section (marshaled as JSON) or a single entry's value.
+func rawConfigPatchValue(cfg []cfgSection, path rawConfigPatchPath) (json.RawMessage, error) {
+	sectionIdx, ok := findRawConfigSection(cfg, path)
+	if !ok {
+		return nil, fmt.Errorf("Section %q[%d] not found", path.sectionName, path.index)
+	}
+
+	if path.entryKey == "" {
+		return json.Marshal(toRawConfigPatchSection(cfg[sectionIdx]))
+	}
+
+	entryIdx, ok := findRawConfigEntry(cfg[sectionIdx], path.entryKey)
+	if !ok {
+		return nil, fmt.Errorf("Entry %q not found in section %q[%d]", path.entryKey, path.sectionName, path.index)
+	}
+
+	return json.Marshal(cfg[sectionIdx].entries[entryIdx].value)
+}
+
+func rawConfigPatchTest(cfg []cfgSection, path rawConfigPatchPath, value json.RawMessage) error {
+	current, err := rawConfigPatchValue(cfg, path)
+	if err != nil {
+		return err
+	}
+
+	var want, got any
+
+	err = json.Unmarshal(value, &want)
+	if err != nil {
+		return fmt.Errorf("Invalid test value: %w", err)
+	}
+
+	err = json.Unmarshal(current, &got)
+	if err != nil {
+		return fmt.Errorf("Invalid current value: %w", err)
+	}
+
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if string(wantJSON) != string(gotJSON) {
+		return fmt.Errorf("Test failed: expected %s, found %s", wantJSON, gotJSON)
+	}
+
+	return nil
+}
+
+func rawConfigPatchAdd(cfg []cfgSection, path rawConfigPatchPath, value json.RawMessage) ([]cfgSection, error) {
+	if path.entryKey == "" {
+		var section rawConfigPatchSection
+		err := json.Unmarshal(value, &section)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid section value: %w", err)
+		}
+
+		return append(cfg, fromRawConfigPatchSection(path.sectionName, section)), nil
+	}
+
+	sectionIdx, ok := findRawConfigSection(cfg, path)
+	if !ok {
+		return nil, fmt.Errorf("Section %q[%d] not found", path.sectionName, path.index)
+	}
+
+	var entryValue string
+	err := json.Unmarshal(value, &entryValue)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid entry value: %w", err)
+	}
+
+	section := &cfg[sectionIdx]
+	entryIdx, ok := findRawConfigEntry(*section, path.entryKey)
+	if ok {
+		// "add" on an already-present key replaces its value, per RFC 6902.
+		section.entries[entryIdx].value = entryValue
+	} else {
+		section.entries = append(section.entries, cfgEntry{key: path.entryKey, value: entryValue})
+	}
+
+	return cfg, nil
+}
+
+func rawConfigPatchReplace(cfg []cfgSection, path rawConfigPatchPath, value json.RawMessage) ([]cfgSection, error) {
+	sectionIdx, ok := findRawConfigSection(cfg, path)
+	if !ok {
+		return nil, fmt.Errorf("Section %q[%d] not found", path.sectionName, path.index)
+	}
+
+	if path.entryKey == "" {
+		var section rawConfigPatchSection
+		err := json.Unmarshal(value, &section)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid section value: %w", err)
+		}
+
+		cfg[sectionIdx] = fromRawConfigPatchSection(path.sectionName, section)
+
+		return cfg, nil
+	}
+
+	entryIdx, ok := findRawConfigEntry(cfg[sectionIdx], path.entryKey)
+	if !ok {
+		return nil, fmt.Errorf("Entry %q not found in section %q[%d]", path.entryKey, path.sectionName, path.index)
+	}
+
+	var entryValue string
+	err := json.Unmarshal(value, &entryValue)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid entry value: %w", err)
+	}
+
+	cfg[sectionIdx].entries[entryIdx].value = entryValue
+
+	return cfg, nil
+}
+
+func rawConfigPatchRemove(cfg []cfgSection, path rawConfigPatchPath) ([]cfgSection, error) {
+	sectionIdx, ok := findRawConfigSection(cfg, path)
+	if !ok {
+		return nil, fmt.Errorf("Section %q[%d] not found", path.sectionName, path.index)
+	}
+
+	if path.entryKey == "" {
+		return append(cfg[:sectionIdx], cfg[sectionIdx+1:]...), nil
+	}
+
+	entryIdx, ok := findRawConfigEntry(cfg[sectionIdx], path.entryKey)
+	if !ok {
+		return nil, fmt.Errorf("Entry %q not found in section %q[%d]", path.entryKey, path.sectionName, path.index)
+	}
+
+	section := &cfg[sectionIdx]
+	section.entries = append(section.entries[:entryIdx], section.entries[entryIdx+1:]...)
+
+	return cfg, nil
+}
+
+// rawConfigPatchMove implements both "move" (remove==true) and "copy"
+// (remove==false), which only differ in whether the source is removed.
+func rawConfigPatchMove(cfg []cfgSection, fromRaw string, to rawConfigPatchPath, remove bool) ([]cfgSection, error) {
+	from, err := parseRawConfigPatchPath(fromRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := rawConfigPatchValue(cfg, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if remove {
+		cfg, err = rawConfigPatchRemove(cfg, from)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if to.entryKey == "" {
+		var section rawConfigPatchSection
+		err = json.Unmarshal(value, &section)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid section value: %w", err)
+		}
+
+		// Insert rather than overwrite: to.index may already be occupied
+		// (e.g. reordering sections via move), and overwriting would
+		// silently drop whatever section currently sits there.
+		return insertRawConfigSection(cfg, to, fromRawConfigPatchSection(to.sectionName, section)), nil
+	}
+
+	if _, ok := findRawConfigSection(cfg, to); !ok {
+		return nil, fmt.Errorf("Section %q[%d] not found", to.sectionName, to.index)
+	}
+
+	return rawConfigPatchAdd(cfg, to, value)
+}
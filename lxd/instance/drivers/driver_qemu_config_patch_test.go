@@ -0,0 +1,241 @@
+package drivers
+
+import "testing"
+
+func TestQemuApplyRawConfigPatchReplace(t *testing.T) {
+	cfg := []cfgSection{
+		{name: "pci", entries: []cfgEntry{{key: "romfile", value: "a"}}},
+	}
+
+	out, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "replace", Path: "/pci[0]/romfile", Value: []byte(`"b"`)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if out[0].entries[0].value != "b" {
+		t.Fatalf("expected romfile=b, got %q", out[0].entries[0].value)
+	}
+}
+
+func TestQemuApplyRawConfigPatchDoesNotMutateInput(t *testing.T) {
+	cfg := []cfgSection{
+		{name: "pci", entries: []cfgEntry{{key: "romfile", value: "a"}}},
+	}
+
+	ops := []rawConfigPatchOp{
+		{Op: "replace", Path: "/pci[0]/romfile", Value: []byte(`"b"`)},
+	}
+
+	// Exercised both directly and through the public entry point, since the
+	// caller's []cfgSection must survive either path untouched.
+	_, err := qemuApplyRawConfigPatch(cfg, ops)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg[0].entries[0].value != "a" {
+		t.Fatalf("input mutated: expected romfile=a, got %q", cfg[0].entries[0].value)
+	}
+
+	_, err = qemuRawCfgOverride(cfg, map[string]string{
+		"raw.qemu.config.patch": `[{"op":"replace","path":"/pci[0]/romfile","value":"b"}]`,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg[0].entries[0].value != "a" {
+		t.Fatalf("input mutated via qemuRawCfgOverride: expected romfile=a, got %q", cfg[0].entries[0].value)
+	}
+}
+
+func TestQemuApplyRawConfigPatchAddEntry(t *testing.T) {
+	cfg := []cfgSection{
+		{name: "chardev", entries: []cfgEntry{{key: "backend", value: "pty"}}},
+	}
+
+	out, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "add", Path: "/chardev[0]/logfile", Value: []byte(`"/tmp/log"`)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(out[0].entries) != 2 || out[0].entries[1].key != "logfile" || out[0].entries[1].value != "/tmp/log" {
+		t.Fatalf("expected logfile entry to be appended, got %+v", out[0].entries)
+	}
+}
+
+func TestQemuApplyRawConfigPatchAddSection(t *testing.T) {
+	cfg := []cfgSection{}
+
+	out, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "add", Path: "/chardev", Value: []byte(`{"entries":[{"key":"backend","value":"pty"}]}`)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(out) != 1 || out[0].name != "chardev" || out[0].entries[0].value != "pty" {
+		t.Fatalf("expected new chardev section, got %+v", out)
+	}
+}
+
+func TestQemuApplyRawConfigPatchRemoveEntry(t *testing.T) {
+	cfg := []cfgSection{
+		{name: "chardev", entries: []cfgEntry{{key: "backend", value: "pty"}, {key: "logfile", value: "/tmp/log"}}},
+	}
+
+	out, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "remove", Path: "/chardev[0]/logfile"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(out[0].entries) != 1 || out[0].entries[0].key != "backend" {
+		t.Fatalf("expected only backend entry to remain, got %+v", out[0].entries)
+	}
+}
+
+func TestQemuApplyRawConfigPatchRemoveSection(t *testing.T) {
+	cfg := []cfgSection{
+		{name: "chardev", entries: []cfgEntry{{key: "backend", value: "pty"}}},
+		{name: "chardev", entries: []cfgEntry{{key: "backend", value: "socket"}}},
+	}
+
+	out, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "remove", Path: "/chardev[0]"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(out) != 1 || out[0].entries[0].value != "socket" {
+		t.Fatalf("expected only the socket chardev to remain, got %+v", out)
+	}
+}
+
+func TestQemuApplyRawConfigPatchMove(t *testing.T) {
+	cfg := []cfgSection{
+		{name: "chardev", entries: []cfgEntry{{key: "backend", value: "pty"}}},
+	}
+
+	out, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "move", From: "/chardev[0]/backend", Path: "/chardev[0]/terminal"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(out[0].entries) != 1 || out[0].entries[0].key != "terminal" || out[0].entries[0].value != "pty" {
+		t.Fatalf("expected backend renamed to terminal, got %+v", out[0].entries)
+	}
+}
+
+func TestQemuApplyRawConfigPatchMoveSectionToOccupiedIndex(t *testing.T) {
+	cfg := []cfgSection{
+		{name: "chardev", entries: []cfgEntry{{key: "id", value: "a"}}},
+		{name: "chardev", entries: []cfgEntry{{key: "id", value: "b"}}},
+		{name: "chardev", entries: []cfgEntry{{key: "id", value: "c"}}},
+	}
+
+	out, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "move", From: "/chardev[2]", Path: "/chardev[0]"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(out) != 3 {
+		t.Fatalf("expected all 3 chardevs to survive the reorder, got %+v", out)
+	}
+
+	got := []string{out[0].entries[0].value, out[1].entries[0].value, out[2].entries[0].value}
+	want := []string{"c", "a", "b"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected chardevs reordered to %v, got %v", want, got)
+		}
+	}
+}
+
+func TestQemuApplyRawConfigPatchCopySectionToOccupiedIndex(t *testing.T) {
+	cfg := []cfgSection{
+		{name: "chardev", entries: []cfgEntry{{key: "id", value: "a"}}},
+		{name: "chardev", entries: []cfgEntry{{key: "id", value: "b"}}},
+	}
+
+	out, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "copy", From: "/chardev[1]", Path: "/chardev[0]"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(out) != 3 {
+		t.Fatalf("expected source to be preserved and the copy inserted, got %+v", out)
+	}
+
+	got := []string{out[0].entries[0].value, out[1].entries[0].value, out[2].entries[0].value}
+	want := []string{"b", "a", "b"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestQemuApplyRawConfigPatchCopy(t *testing.T) {
+	cfg := []cfgSection{
+		{name: "chardev", entries: []cfgEntry{{key: "backend", value: "pty"}}},
+	}
+
+	out, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "copy", From: "/chardev[0]", Path: "/chardev[1]"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(out) != 2 {
+		t.Fatalf("expected source chardev to be preserved and a copy appended, got %+v", out)
+	}
+
+	if out[0].entries[0].value != "pty" || out[1].entries[0].value != "pty" {
+		t.Fatalf("expected both chardevs to carry backend=pty, got %+v", out)
+	}
+}
+
+func TestQemuApplyRawConfigPatchTest(t *testing.T) {
+	cfg := []cfgSection{
+		{name: "chardev", entries: []cfgEntry{{key: "backend", value: "pty"}}},
+	}
+
+	_, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "test", Path: "/chardev[0]/backend", Value: []byte(`"socket"`)},
+	})
+	if err == nil {
+		t.Fatalf("expected test op to fail on value mismatch")
+	}
+
+	_, err = qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "test", Path: "/chardev[0]/backend", Value: []byte(`"pty"`)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on matching test op: %v", err)
+	}
+}
+
+func TestQemuApplyRawConfigPatchMissingSection(t *testing.T) {
+	cfg := []cfgSection{}
+
+	_, err := qemuApplyRawConfigPatch(cfg, []rawConfigPatchOp{
+		{Op: "replace", Path: "/chardev[0]/backend", Value: []byte(`"pty"`)},
+	})
+	if err == nil {
+		t.Fatalf("expected error for missing section")
+	}
+}
@@ -4,34 +4,148 @@ package operations
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"math/rand"
+	"strings"
+	"time"
 
 	"github.com/lxc/lxd/lxd/db"
 	"github.com/lxc/lxd/lxd/db/cluster"
+	"github.com/lxc/lxd/shared/logger"
 )
 
-func registerDBOperation(op *Operation, opType db.OperationType) error {
+// Bounds for the exponential backoff applied by withClusterTx between
+// retries of a dqlite cluster transaction.
+const (
+	clusterTxRetryMinDelay = 5 * time.Millisecond
+	clusterTxRetryMaxDelay = time.Second
+	clusterTxRetryMaxCount = 8
+)
+
+// isRetriableClusterError reports whether err looks like a transient dqlite
+// condition (the local node isn't the leader, a leadership change is in
+// progress, or the database is momentarily locked) that's worth retrying
+// rather than failing the operation outright.
+func isRetriableClusterError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+
+	return strings.Contains(msg, "database is locked") ||
+		strings.Contains(msg, "no known leader") ||
+		strings.Contains(msg, "leadership lost") ||
+		strings.Contains(msg, "not leader")
+}
+
+// withClusterTx retries run with exponential backoff and jitter on transient
+// dqlite errors, while honoring ctx cancellation, and logs each attempt with
+// structured fields. label identifies the caller for the log entries (e.g.
+// "register-operation"). run is expected to wrap a single
+// state.DB.Cluster.Transaction call; keeping that call inside run (rather
+// than threading *db.ClusterTx through this helper) keeps withClusterTx
+// itself free of dqlite types and so independently testable.
+func withClusterTx(ctx context.Context, op *Operation, label string, run func(ctx context.Context) error) error {
 	if op.state == nil {
 		return nil
 	}
 
-	err := op.state.DB.Cluster.Transaction(context.TODO(), func(ctx context.Context, tx *db.ClusterTx) error {
-		opInfo := db.Operation{
-			UUID:   op.id,
-			Type:   opType,
-			NodeID: tx.GetNodeID(),
+	delay := clusterTxRetryMinDelay
+
+	var err error
+	for attempt := 1; attempt <= clusterTxRetryMaxCount; attempt++ {
+		err = run(ctx)
+		if err == nil || !isRetriableClusterError(err) {
+			break
+		}
+
+		logger.Warn("Retrying cluster operation transaction", logger.Ctx{
+			"op_id":   op.id,
+			"op_type": label,
+			"project": op.projectName,
+			"attempt": attempt,
+			"err":     err,
+		})
+
+		if attempt == clusterTxRetryMaxCount {
+			// Last attempt: the loop is about to exit on its own, so
+			// backing off here would only add latency without buying
+			// another retry.
+			break
 		}
 
-		if op.projectName != "" {
-			projectID, err := cluster.GetProjectID(context.Background(), tx.Tx(), op.projectName)
-			if err != nil {
-				return fmt.Errorf("Fetch project ID: %w", err)
-			}
-			opInfo.ProjectID = &projectID
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay + time.Duration(rand.Int63n(int64(delay)+1))):
 		}
 
-		_, err := tx.CreateOrReplaceOperation(opInfo)
-		return err
+		delay *= 2
+		if delay > clusterTxRetryMaxDelay {
+			delay = clusterTxRetryMaxDelay
+		}
+	}
+
+	if err != nil {
+		logger.Error("Cluster operation transaction failed", logger.Ctx{
+			"op_id":   op.id,
+			"op_type": label,
+			"project": op.projectName,
+			"err":     err,
+		})
+
+		return fmt.Errorf("%s: %w", label, err)
+	}
+
+	return nil
+}
+
+// registerOperationTx is the subset of *db.ClusterTx used by
+// registerOperation, factored out so tests can exercise the write path
+// against a fake that simulates transient dqlite errors without a real
+// cluster.
+type registerOperationTx interface {
+	GetNodeID() int64
+	Tx() *sql.Tx
+	CreateOrReplaceOperation(db.Operation) (int64, error)
+}
+
+// registerOperation builds the db.Operation row for op and writes it via tx.
+// Split out of registerDBOperation so it can be driven directly in tests.
+func registerOperation(ctx context.Context, tx registerOperationTx, op *Operation, opType db.OperationType) error {
+	opInfo := db.Operation{
+		UUID:   op.id,
+		Type:   opType,
+		NodeID: tx.GetNodeID(),
+	}
+
+	if op.projectName != "" {
+		projectID, err := cluster.GetProjectID(ctx, tx.Tx(), op.projectName)
+		if err != nil {
+			return fmt.Errorf("Fetch project ID: %w", err)
+		}
+		opInfo.ProjectID = &projectID
+	}
+
+	_, err := tx.CreateOrReplaceOperation(opInfo)
+	return err
+}
+
+func registerDBOperation(op *Operation, opType db.OperationType) error {
+	if op.state == nil {
+		return nil
+	}
+
+	// context.Background() is a placeholder: Operation doesn't currently
+	// expose a cancellation signal (e.g. a done channel) for callers to tie
+	// this transaction to, so withClusterTx's own ctx-honoring retry loop is
+	// only reachable from tests until Operation grows one.
+	err := withClusterTx(context.Background(), op, "register-operation", func(ctx context.Context) error {
+		return op.state.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			return registerOperation(ctx, tx, op, opType)
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("failed to add %q Operation %s to database: %w", opType.Description(), op.id, err)
@@ -45,11 +159,14 @@ func removeDBOperation(op *Operation) error {
 		return nil
 	}
 
-	err := op.state.DB.Cluster.Transaction(context.TODO(), func(ctx context.Context, tx *db.ClusterTx) error {
-		return tx.DeleteOperation(op.id)
+	// See the comment in registerDBOperation: context.Background() here is
+	// also a placeholder pending Operation exposing a real cancellation
+	// signal.
+	return withClusterTx(context.Background(), op, "remove-operation", func(ctx context.Context) error {
+		return op.state.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			return tx.DeleteOperation(op.id)
+		})
 	})
-
-	return err
 }
 
 func getServerName(op *Operation) (string, error) {
@@ -57,11 +174,16 @@ func getServerName(op *Operation) (string, error) {
 		return "", nil
 	}
 
+	// See the comment in registerDBOperation: context.Background() here is
+	// also a placeholder pending Operation exposing a real cancellation
+	// signal.
 	var serverName string
-	var err error
-	err = op.state.DB.Cluster.Transaction(context.TODO(), func(ctx context.Context, tx *db.ClusterTx) error {
-		serverName, err = tx.GetLocalNodeName()
-		return err
+	err := withClusterTx(context.Background(), op, "get-server-name", func(ctx context.Context) error {
+		return op.state.DB.Cluster.Transaction(ctx, func(ctx context.Context, tx *db.ClusterTx) error {
+			var err error
+			serverName, err = tx.GetLocalNodeName()
+			return err
+		})
 	})
 	if err != nil {
 		return "", err
@@ -0,0 +1,174 @@
+//go:build linux && cgo && !agent
+
+package operations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lxc/lxd/lxd/db"
+	"github.com/lxc/lxd/lxd/state"
+)
+
+// fakeRegisterOperationTx implements registerOperationTx, failing the first
+// failCount calls to CreateOrReplaceOperation with a retriable dqlite error
+// before succeeding, so registerOperation's retry path can be driven without
+// a real cluster.
+type fakeRegisterOperationTx struct {
+	failCount int
+
+	calls      int
+	registered []db.Operation
+}
+
+func (f *fakeRegisterOperationTx) GetNodeID() int64 { return 1 }
+
+func (f *fakeRegisterOperationTx) Tx() *sql.Tx { return nil }
+
+func (f *fakeRegisterOperationTx) CreateOrReplaceOperation(op db.Operation) (int64, error) {
+	f.calls++
+	if f.calls <= f.failCount {
+		return 0, errors.New("database is locked")
+	}
+
+	f.registered = append(f.registered, op)
+	return int64(len(f.registered)), nil
+}
+
+func TestWithClusterTxRetriesOnBusy(t *testing.T) {
+	op := &Operation{
+		id:    "test-op",
+		state: &state.State{},
+	}
+
+	var attempts int
+	err := withClusterTx(context.Background(), op, "register-operation", func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("database is locked")
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected operation to eventually succeed, got: %v", err)
+	}
+
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestWithClusterTxDoesNotRetryNonRetriableError(t *testing.T) {
+	op := &Operation{
+		id:    "test-op",
+		state: &state.State{},
+	}
+
+	boom := errors.New("boom")
+
+	var attempts int
+	err := withClusterTx(context.Background(), op, "register-operation", func(ctx context.Context) error {
+		attempts++
+		return boom
+	})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	if attempts != 1 {
+		t.Fatalf("expected a single attempt for a non-retriable error, got %d", attempts)
+	}
+}
+
+func TestWithClusterTxAbortsOnContextCancel(t *testing.T) {
+	op := &Operation{
+		id:    "test-op",
+		state: &state.State{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var attempts int
+	err := withClusterTx(ctx, op, "register-operation", func(ctx context.Context) error {
+		attempts++
+		return errors.New("database is locked")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+
+	if attempts != 1 {
+		t.Fatalf("expected a single attempt before the cancel is observed, got %d", attempts)
+	}
+}
+
+func TestWithClusterTxDoesNotSleepAfterFinalAttempt(t *testing.T) {
+	op := &Operation{
+		id:    "test-op",
+		state: &state.State{},
+	}
+
+	start := time.Now()
+
+	var attempts int
+	err := withClusterTx(context.Background(), op, "register-operation", func(ctx context.Context) error {
+		attempts++
+		return errors.New("database is locked")
+	})
+	if err == nil {
+		t.Fatalf("expected the persistently failing operation to return an error")
+	}
+
+	if attempts != clusterTxRetryMaxCount {
+		t.Fatalf("expected %d attempts, got %d", clusterTxRetryMaxCount, attempts)
+	}
+
+	// clusterTxRetryMaxCount-1 backoffs are expected between attempts; the
+	// final attempt must not be followed by one more, so the total elapsed
+	// time should stay well under what clusterTxRetryMaxCount backoffs would
+	// take. Even with no jitter, clusterTxRetryMaxCount backoffs take at least
+	// 5+10+...+640ms = 1275ms, while the clusterTxRetryMaxCount-1 expected
+	// ones take at most 1270ms with maximal jitter.
+	var minAllBackoffs time.Duration
+	delay := clusterTxRetryMinDelay
+	for i := 0; i < clusterTxRetryMaxCount; i++ {
+		minAllBackoffs += delay
+		delay *= 2
+		if delay > clusterTxRetryMaxDelay {
+			delay = clusterTxRetryMaxDelay
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed >= minAllBackoffs {
+		t.Fatalf("expected no backoff after the final attempt, took %s", elapsed)
+	}
+}
+
+func TestRegisterOperationRetriesOnBusyThenRegisters(t *testing.T) {
+	op := &Operation{
+		id:    "test-op",
+		state: &state.State{},
+	}
+
+	fake := &fakeRegisterOperationTx{failCount: 2}
+
+	err := withClusterTx(context.Background(), op, "register-operation", func(ctx context.Context) error {
+		return registerOperation(ctx, fake, op, db.OperationType(0))
+	})
+	if err != nil {
+		t.Fatalf("expected the operation to be registered despite transient errors, got: %v", err)
+	}
+
+	if fake.calls != 3 {
+		t.Fatalf("expected 3 attempts (2 ErrBusy then success), got %d", fake.calls)
+	}
+
+	if len(fake.registered) != 1 || fake.registered[0].UUID != op.id {
+		t.Fatalf("expected the operation to have been registered exactly once, got %+v", fake.registered)
+	}
+}